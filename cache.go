@@ -0,0 +1,117 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2/expirable"
+	"github.com/sashabaranov/go-openai"
+)
+
+// riskyVerdictCache and safeVerdictCache hold Guardian scores keyed by
+// verdictCacheKey, split by outcome so blocks and allows can be tuned with
+// independent TTLs (a block is usually worth caching longer than an
+// allow). Both are sized and expired via GUARDIAN_CACHE_SIZE,
+// GUARDIAN_CACHE_TTL (positive/blocked entries) and
+// GUARDIAN_CACHE_NEGATIVE_TTL (negative/allowed entries).
+var (
+	riskyVerdictCache *lru.LRU[string, float64]
+	safeVerdictCache  *lru.LRU[string, float64]
+)
+
+func init() {
+	newVerdictCaches(envInt("GUARDIAN_CACHE_SIZE", 1024), envDuration("GUARDIAN_CACHE_TTL", 10*time.Minute), envDuration("GUARDIAN_CACHE_NEGATIVE_TTL", 1*time.Minute))
+}
+
+// newVerdictCaches (re)builds riskyVerdictCache and safeVerdictCache with
+// explicit size and TTLs, split out of init so tests can exercise
+// size/TTL behavior directly instead of through package-init-time env vars.
+func newVerdictCaches(size int, positiveTTL, negativeTTL time.Duration) {
+	riskyVerdictCache = lru.NewLRU[string, float64](size, nil, positiveTTL)
+	safeVerdictCache = lru.NewLRU[string, float64](size, nil, negativeTTL)
+}
+
+// verdictCacheKey hashes the model, category and normalized conversation so
+// repeated identical prompts (system prompts, canned test traffic,
+// retries) skip the Guardian round-trip entirely. Normalizing collapses
+// case and whitespace-run differences (indentation, trailing spaces) that
+// don't change what Guardian would see.
+func verdictCacheKey(category RiskCategory, messages []openai.ChatCompletionMessage) string {
+	var sb strings.Builder
+	sb.WriteString(modelName)
+	sb.WriteByte('|')
+	sb.WriteString(string(category))
+	sb.WriteByte('|')
+	for _, m := range messages {
+		sb.WriteString(normalizeForCacheKey(m.Role))
+		sb.WriteByte(':')
+		sb.WriteString(normalizeForCacheKey(m.Content))
+		sb.WriteByte('\n')
+	}
+
+	sum := sha256.Sum256([]byte(sb.String()))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeForCacheKey lowercases text and collapses any run of whitespace
+// (leading, trailing or internal) down to single spaces, so cosmetic
+// differences between otherwise-identical prompts still hit the cache.
+func normalizeForCacheKey(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// lookupVerdictCache checks the risky cache before the safe one, since a
+// cached block is the result most worth avoiding a re-derive for.
+func lookupVerdictCache(key string) (float64, bool) {
+	if score, ok := riskyVerdictCache.Get(key); ok {
+		guardianCacheHitsTotal.WithLabelValues("risky").Inc()
+		return score, true
+	}
+	if score, ok := safeVerdictCache.Get(key); ok {
+		guardianCacheHitsTotal.WithLabelValues("safe").Inc()
+		return score, true
+	}
+	guardianCacheMissesTotal.Inc()
+	return 0, false
+}
+
+// storeVerdictCache files score under the cache matching risky, each with
+// its own size and TTL.
+func storeVerdictCache(key string, score float64, risky bool) {
+	if risky {
+		riskyVerdictCache.Add(key, score)
+		return
+	}
+	safeVerdictCache.Add(key, score)
+}
+
+func envInt(name string, fallback int) int {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	value, err := strconv.Atoi(raw)
+	if err != nil || value <= 0 {
+		log.Printf("[Cache] Ignoring invalid %s=%q, using default %d", name, raw, fallback)
+		return fallback
+	}
+	return value
+}
+
+func envDuration(name string, fallback time.Duration) time.Duration {
+	raw := os.Getenv(name)
+	if raw == "" {
+		return fallback
+	}
+	secs, err := strconv.Atoi(raw)
+	if err != nil || secs <= 0 {
+		log.Printf("[Cache] Ignoring invalid %s=%q, using default %s", name, raw, fallback)
+		return fallback
+	}
+	return time.Duration(secs) * time.Second
+}
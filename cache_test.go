@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestVerdictCacheKey(t *testing.T) {
+	msgsA := []openai.ChatCompletionMessage{{Role: "user", Content: "Hello There"}}
+	msgsB := []openai.ChatCompletionMessage{{Role: "user", Content: "hello   there  "}}
+	msgsC := []openai.ChatCompletionMessage{{Role: "user", Content: "goodbye"}}
+
+	if got := verdictCacheKey(CategoryHarm, msgsA); got != verdictCacheKey(CategoryHarm, msgsB) {
+		t.Errorf("keys for case/whitespace-only variants differ: %q vs %q", got, verdictCacheKey(CategoryHarm, msgsB))
+	}
+	if verdictCacheKey(CategoryHarm, msgsA) == verdictCacheKey(CategoryHarm, msgsC) {
+		t.Error("keys for different conversations collided")
+	}
+	if verdictCacheKey(CategoryHarm, msgsA) == verdictCacheKey(CategoryViolence, msgsA) {
+		t.Error("keys for different categories collided")
+	}
+}
+
+func TestEnvDuration(t *testing.T) {
+	tests := []struct {
+		name     string
+		raw      string
+		fallback time.Duration
+		want     time.Duration
+	}{
+		{name: "unset uses fallback", raw: "", fallback: 10 * time.Second, want: 10 * time.Second},
+		{name: "valid seconds", raw: "30", fallback: 10 * time.Second, want: 30 * time.Second},
+		{name: "invalid falls back", raw: "not-a-number", fallback: 10 * time.Second, want: 10 * time.Second},
+		{name: "non-positive falls back", raw: "0", fallback: 10 * time.Second, want: 10 * time.Second},
+	}
+
+	const envVar = "TEST_GUARDIAN_ENV_DURATION"
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(envVar, tt.raw)
+			if got := envDuration(envVar, tt.fallback); got != tt.want {
+				t.Errorf("envDuration() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCachePositiveAndNegativeTTLsAreIndependent(t *testing.T) {
+	origRisky, origSafe := riskyVerdictCache, safeVerdictCache
+	t.Cleanup(func() { riskyVerdictCache, safeVerdictCache = origRisky, origSafe })
+
+	// Positive (risky) TTL much longer than negative (safe) TTL, so the
+	// safe entry can expire out from under the risky one within the test.
+	newVerdictCaches(4, time.Hour, 10*time.Millisecond)
+
+	riskyKey := verdictCacheKey(CategoryHarm, []openai.ChatCompletionMessage{{Role: "user", Content: "risky"}})
+	safeKey := verdictCacheKey(CategoryHarm, []openai.ChatCompletionMessage{{Role: "user", Content: "safe"}})
+
+	storeVerdictCache(riskyKey, 1.0, true)
+	storeVerdictCache(safeKey, 0.0, false)
+
+	if score, ok := lookupVerdictCache(riskyKey); !ok || score != 1.0 {
+		t.Errorf("risky lookup = (%v, %v), want (1.0, true)", score, ok)
+	}
+	if score, ok := lookupVerdictCache(safeKey); !ok || score != 0.0 {
+		t.Errorf("safe lookup = (%v, %v), want (0.0, true)", score, ok)
+	}
+
+	time.Sleep(20 * time.Millisecond)
+
+	if _, ok := lookupVerdictCache(safeKey); ok {
+		t.Error("safe entry should have expired under its shorter negative TTL")
+	}
+	if _, ok := lookupVerdictCache(riskyKey); !ok {
+		t.Error("risky entry should still be cached under its longer positive TTL")
+	}
+}
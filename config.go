@@ -0,0 +1,165 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RiskCategory identifies one of the risk dimensions Granite Guardian can
+// score a conversation against.
+type RiskCategory string
+
+const (
+	CategoryHarm              RiskCategory = "harm"
+	CategorySocialBias        RiskCategory = "social_bias"
+	CategoryJailbreak         RiskCategory = "jailbreak"
+	CategoryProfanity         RiskCategory = "profanity"
+	CategoryUnethicalBehavior RiskCategory = "unethical_behavior"
+	CategoryViolence          RiskCategory = "violence"
+	CategoryGroundedness      RiskCategory = "groundedness"
+)
+
+// RiskAction is what to do with a request/response once a category's
+// threshold has been crossed.
+type RiskAction string
+
+const (
+	ActionBlock          RiskAction = "block"
+	ActionRedact         RiskAction = "redact"
+	ActionAnnotateHeader RiskAction = "annotate-header"
+	ActionAllow          RiskAction = "allow"
+)
+
+// OnErrorPolicy controls what happens to in-flight traffic when the
+// Guardian API itself errors out or is unreachable.
+type OnErrorPolicy string
+
+const (
+	OnErrorFailOpen   OnErrorPolicy = "fail_open"
+	OnErrorFailClosed OnErrorPolicy = "fail_closed"
+)
+
+// validCategories, validActions and validOnErrorPolicies enumerate the
+// values loadConfig accepts for the corresponding fields - anything else is
+// almost certainly a typo in the config file, and for a safety gate a typo
+// that silently degrades "block" to "allow" is a real hole, so it must fail
+// startup rather than fall through to a default.
+var (
+	validCategories = map[RiskCategory]bool{
+		CategoryHarm:              true,
+		CategorySocialBias:        true,
+		CategoryJailbreak:         true,
+		CategoryProfanity:         true,
+		CategoryUnethicalBehavior: true,
+		CategoryViolence:          true,
+		CategoryGroundedness:      true,
+	}
+	validActions = map[RiskAction]bool{
+		ActionBlock:          true,
+		ActionRedact:         true,
+		ActionAnnotateHeader: true,
+		ActionAllow:          true,
+	}
+	validOnErrorPolicies = map[OnErrorPolicy]bool{
+		OnErrorFailOpen:   true,
+		OnErrorFailClosed: true,
+	}
+)
+
+// CategoryPolicy is one entry of the config's "categories" map.
+type CategoryPolicy struct {
+	Enabled   bool       `yaml:"enabled" json:"enabled"`
+	Threshold float64    `yaml:"threshold" json:"threshold"`
+	Action    RiskAction `yaml:"action" json:"action"`
+}
+
+// Config is the guardian-ext-proc risk policy, loaded once at startup from
+// the file at GUARDIAN_CONFIG_PATH.
+type Config struct {
+	Categories map[RiskCategory]CategoryPolicy `yaml:"categories" json:"categories"`
+	OnError    OnErrorPolicy                   `yaml:"on_error" json:"on_error"`
+}
+
+// defaultConfig reproduces the server's original behavior - a single harm
+// Yes/No check that fails closed - for deployments that don't set
+// GUARDIAN_CONFIG_PATH.
+func defaultConfig() *Config {
+	return &Config{
+		Categories: map[RiskCategory]CategoryPolicy{
+			CategoryHarm: {Enabled: true, Threshold: 0.5, Action: ActionBlock},
+		},
+		OnError: OnErrorFailClosed,
+	}
+}
+
+// loadConfig reads the risk policy from GUARDIAN_CONFIG_PATH (YAML, or JSON
+// if the path ends in .json), falling back to defaultConfig if the env var
+// is unset.
+func loadConfig() (*Config, error) {
+	path := os.Getenv("GUARDIAN_CONFIG_PATH")
+	if path == "" {
+		log.Println("[Config] GUARDIAN_CONFIG_PATH not set, using default risk policy")
+		return defaultConfig(), nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading guardian config %q: %w", path, err)
+	}
+
+	cfg := &Config{}
+	if strings.HasSuffix(path, ".json") {
+		err = json.Unmarshal(data, cfg)
+	} else {
+		err = yaml.Unmarshal(data, cfg)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing guardian config %q: %w", path, err)
+	}
+
+	if cfg.OnError == "" {
+		cfg.OnError = OnErrorFailClosed
+	}
+	if err := cfg.validate(); err != nil {
+		return nil, fmt.Errorf("invalid guardian config %q: %w", path, err)
+	}
+	log.Printf("[Config] Loaded risk policy from %s: %d categories, on_error=%s", path, len(cfg.Categories), cfg.OnError)
+	return cfg, nil
+}
+
+// validate rejects an unrecognized category, action or on_error value
+// instead of letting it fall through decideAction's priority map as an
+// unknown-action-means-allow default.
+func (c *Config) validate() error {
+	if !validOnErrorPolicies[c.OnError] {
+		return fmt.Errorf("on_error: unrecognized value %q", c.OnError)
+	}
+	for cat, policy := range c.Categories {
+		if !validCategories[cat] {
+			return fmt.Errorf("categories: unrecognized category %q", cat)
+		}
+		if !validActions[policy.Action] {
+			return fmt.Errorf("categories.%s.action: unrecognized action %q", cat, policy.Action)
+		}
+	}
+	return nil
+}
+
+// enabledCategories returns the categories turned on in the policy, sorted
+// so repeated Guardian calls happen in a deterministic order.
+func (c *Config) enabledCategories() []RiskCategory {
+	var cats []RiskCategory
+	for cat, policy := range c.Categories {
+		if policy.Enabled {
+			cats = append(cats, cat)
+		}
+	}
+	sort.Slice(cats, func(i, j int) bool { return cats[i] < cats[j] })
+	return cats
+}
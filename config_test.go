@@ -0,0 +1,113 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeConfigFile(t *testing.T, name, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(contents), 0o600); err != nil {
+		t.Fatalf("writing test config: %v", err)
+	}
+	return path
+}
+
+func TestLoadConfig(t *testing.T) {
+	t.Run("unset path falls back to default policy", func(t *testing.T) {
+		t.Setenv("GUARDIAN_CONFIG_PATH", "")
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+		if cfg.OnError != OnErrorFailClosed {
+			t.Errorf("OnError = %q, want %q", cfg.OnError, OnErrorFailClosed)
+		}
+		if policy := cfg.Categories[CategoryHarm]; !policy.Enabled || policy.Action != ActionBlock {
+			t.Errorf("harm policy = %+v, want enabled block policy", policy)
+		}
+	})
+
+	t.Run("parses yaml", func(t *testing.T) {
+		path := writeConfigFile(t, "policy.yaml", `
+on_error: fail_open
+categories:
+  jailbreak:
+    enabled: true
+    threshold: 0.7
+    action: redact
+`)
+		t.Setenv("GUARDIAN_CONFIG_PATH", path)
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+		if cfg.OnError != OnErrorFailOpen {
+			t.Errorf("OnError = %q, want %q", cfg.OnError, OnErrorFailOpen)
+		}
+		policy, ok := cfg.Categories[CategoryJailbreak]
+		if !ok || policy.Threshold != 0.7 || policy.Action != ActionRedact {
+			t.Errorf("jailbreak policy = %+v, want threshold 0.7 action redact", policy)
+		}
+	})
+
+	t.Run("parses json", func(t *testing.T) {
+		path := writeConfigFile(t, "policy.json", `{
+			"on_error": "fail_closed",
+			"categories": {"violence": {"enabled": true, "threshold": 0.4, "action": "block"}}
+		}`)
+		t.Setenv("GUARDIAN_CONFIG_PATH", path)
+		cfg, err := loadConfig()
+		if err != nil {
+			t.Fatalf("loadConfig() error = %v", err)
+		}
+		if policy := cfg.Categories[CategoryViolence]; policy.Action != ActionBlock {
+			t.Errorf("violence policy = %+v, want action block", policy)
+		}
+	})
+
+	t.Run("rejects unrecognized action", func(t *testing.T) {
+		path := writeConfigFile(t, "policy.yaml", `
+categories:
+  harm:
+    enabled: true
+    threshold: 0.5
+    action: blocked
+`)
+		t.Setenv("GUARDIAN_CONFIG_PATH", path)
+		if _, err := loadConfig(); err == nil {
+			t.Error("loadConfig() error = nil, want error for unrecognized action")
+		}
+	})
+
+	t.Run("rejects unrecognized category", func(t *testing.T) {
+		path := writeConfigFile(t, "policy.yaml", `
+categories:
+  not_a_real_category:
+    enabled: true
+    threshold: 0.5
+    action: block
+`)
+		t.Setenv("GUARDIAN_CONFIG_PATH", path)
+		if _, err := loadConfig(); err == nil {
+			t.Error("loadConfig() error = nil, want error for unrecognized category")
+		}
+	})
+
+	t.Run("rejects unrecognized on_error", func(t *testing.T) {
+		path := writeConfigFile(t, "policy.yaml", `
+on_error: fail_loudly
+categories:
+  harm:
+    enabled: true
+    threshold: 0.5
+    action: block
+`)
+		t.Setenv("GUARDIAN_CONFIG_PATH", path)
+		if _, err := loadConfig(); err == nil {
+			t.Error("loadConfig() error = nil, want error for unrecognized on_error")
+		}
+	})
+}
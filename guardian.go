@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/sashabaranov/go-openai"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Verdict is Granite Guardian's read on one risk category for a given
+// conversation.
+type Verdict struct {
+	Category RiskCategory
+	Score    float64
+	Risky    bool
+	Action   RiskAction
+}
+
+// checkRisk scores messages against every enabled category in
+// guardianConfig, issuing one Guardian call per category so each gets the
+// full conversational context it is trained on. phase ("request" or
+// "response") only labels metrics/spans - it has no bearing on the check
+// itself.
+func checkRisk(ctx context.Context, messages []openai.ChatCompletionMessage, phase string) ([]Verdict, error) {
+	ctx, span := tracer.Start(ctx, "guardian.check_risk")
+	defer span.End()
+
+	categories := guardianConfig.enabledCategories()
+	verdicts := make([]Verdict, 0, len(categories))
+
+	for _, category := range categories {
+		policy := guardianConfig.Categories[category]
+
+		key := verdictCacheKey(category, messages)
+		score, cached := lookupVerdictCache(key)
+		if !cached {
+			var err error
+			score, err = scoreCategory(ctx, messages, category, phase)
+			if err != nil {
+				guardianDecisionsTotal.WithLabelValues(phase, string(category), "error").Inc()
+				return nil, fmt.Errorf("guardian check for category %q: %w", category, err)
+			}
+			storeVerdictCache(key, score, score >= policy.Threshold)
+		}
+
+		verdict := Verdict{
+			Category: category,
+			Score:    score,
+			Risky:    score >= policy.Threshold,
+			Action:   policy.Action,
+		}
+		verdicts = append(verdicts, verdict)
+
+		label := "safe"
+		if verdict.Risky {
+			label = "risky"
+		}
+		guardianDecisionsTotal.WithLabelValues(phase, string(category), label).Inc()
+	}
+
+	return verdicts, nil
+}
+
+// scoreCategory asks Granite Guardian to judge messages against a single
+// category, via a "criteria" system turn prepended ahead of the
+// conversation. Guardian's Yes/No verdict is mapped to a 0/1 score so it
+// can be compared against the category's configured threshold.
+func scoreCategory(ctx context.Context, messages []openai.ChatCompletionMessage, category RiskCategory, phase string) (float64, error) {
+	_, span := tracer.Start(ctx, "guardian.score_category", trace.WithAttributes(
+		attribute.String("guardian.category", string(category)),
+		attribute.String("guardian.phase", phase),
+	))
+	defer span.End()
+
+	log.Printf("👮‍♀️ [Guardian] Checking category %q on %d message(s)\n", category, len(messages))
+	log.Printf("→ Sending to: %s/chat/completions with model '%s'\n", fullBaseURL, modelName)
+
+	criteriaMessages := append([]openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleSystem,
+			Content: fmt.Sprintf("criteria: %s", category),
+		},
+	}, messages...)
+
+	start := time.Now()
+	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
+		Model:       modelName,
+		Messages:    criteriaMessages,
+		Temperature: 0.01,
+		MaxTokens:   50,
+	})
+	guardianCheckDuration.WithLabelValues(phase).Observe(time.Since(start).Seconds())
+	if err != nil {
+		span.RecordError(err)
+		return 0, err
+	}
+
+	result := strings.TrimSpace(resp.Choices[0].Message.Content)
+	log.Printf("🛡️ Risk Model Response for %q: %s\n", category, result)
+
+	if strings.EqualFold(result, riskyToken) {
+		return 1.0, nil
+	}
+	return 0.0, nil
+}
+
+// decideAction picks the highest-priority action among the categories whose
+// threshold was crossed (block shadows redact shadows annotate-header), so
+// a request tripping several categories at once doesn't race between them.
+func decideAction(verdicts []Verdict) (RiskAction, *Verdict) {
+	priority := map[RiskAction]int{
+		ActionBlock:          3,
+		ActionRedact:         2,
+		ActionAnnotateHeader: 1,
+		ActionAllow:          0,
+	}
+
+	var winner *Verdict
+	for i := range verdicts {
+		v := &verdicts[i]
+		if !v.Risky {
+			continue
+		}
+		if winner == nil || priority[v.Action] > priority[winner.Action] {
+			winner = v
+		}
+	}
+	if winner == nil {
+		return ActionAllow, nil
+	}
+	return winner.Action, winner
+}
+
+// assistantMessage wraps a single piece of generated text as the lone
+// message in a risk-check request, for callers that only have the model's
+// output and not the surrounding conversation (e.g. the legacy completions
+// response path).
+func assistantMessage(content string) []openai.ChatCompletionMessage {
+	return []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleAssistant,
+			Content: content,
+		},
+	}
+}
+
+// extractChatMessages builds the conversation Guardian should see out of a
+// request body. It understands two shapes: the modern chat/completions
+// "messages": [{role, content}, ...] array (detected either by the path
+// stashed from RequestHeaders or by the field's presence, since some
+// deployments proxy the chat endpoint under a different path), and the
+// legacy single "prompt" string, which it wraps as one user turn.
+func extractChatMessages(path string, bodyMap map[string]interface{}) []openai.ChatCompletionMessage {
+	rawMessages, hasMessages := bodyMap["messages"].([]interface{})
+	if strings.Contains(path, "/chat/completions") || hasMessages {
+		messages := make([]openai.ChatCompletionMessage, 0, len(rawMessages))
+		for _, rm := range rawMessages {
+			entry, ok := rm.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			role, _ := entry["role"].(string)
+			content, _ := entry["content"].(string)
+			if role == "" || content == "" {
+				continue
+			}
+			messages = append(messages, openai.ChatCompletionMessage{
+				Role:    role,
+				Content: content,
+			})
+		}
+		return messages
+	}
+
+	prompt, _ := bodyMap["prompt"].(string)
+	return []openai.ChatCompletionMessage{
+		{
+			Role:    openai.ChatMessageRoleUser,
+			Content: prompt,
+		},
+	}
+}
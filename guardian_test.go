@@ -0,0 +1,128 @@
+package main
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/sashabaranov/go-openai"
+)
+
+func TestDecideAction(t *testing.T) {
+	tests := []struct {
+		name         string
+		verdicts     []Verdict
+		wantAction   RiskAction
+		wantWinnerAt RiskCategory // "" means no winning verdict
+	}{
+		{
+			name:       "no verdicts risky",
+			verdicts:   []Verdict{{Category: CategoryHarm, Risky: false, Action: ActionBlock}},
+			wantAction: ActionAllow,
+		},
+		{
+			name: "single risky verdict wins",
+			verdicts: []Verdict{
+				{Category: CategoryHarm, Risky: true, Action: ActionRedact},
+			},
+			wantAction:   ActionRedact,
+			wantWinnerAt: CategoryHarm,
+		},
+		{
+			name: "block outranks redact and annotate-header",
+			verdicts: []Verdict{
+				{Category: CategoryProfanity, Risky: true, Action: ActionAnnotateHeader},
+				{Category: CategoryHarm, Risky: true, Action: ActionBlock},
+				{Category: CategoryViolence, Risky: true, Action: ActionRedact},
+			},
+			wantAction:   ActionBlock,
+			wantWinnerAt: CategoryHarm,
+		},
+		{
+			name: "redact outranks annotate-header",
+			verdicts: []Verdict{
+				{Category: CategoryProfanity, Risky: true, Action: ActionAnnotateHeader},
+				{Category: CategoryViolence, Risky: true, Action: ActionRedact},
+			},
+			wantAction:   ActionRedact,
+			wantWinnerAt: CategoryViolence,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			action, winner := decideAction(tt.verdicts)
+			if action != tt.wantAction {
+				t.Errorf("action = %q, want %q", action, tt.wantAction)
+			}
+			switch {
+			case tt.wantWinnerAt == "" && winner != nil:
+				t.Errorf("winner = %+v, want nil", winner)
+			case tt.wantWinnerAt != "" && (winner == nil || winner.Category != tt.wantWinnerAt):
+				t.Errorf("winner = %+v, want category %q", winner, tt.wantWinnerAt)
+			}
+		})
+	}
+}
+
+func TestExtractChatMessages(t *testing.T) {
+	tests := []struct {
+		name string
+		path string
+		body string
+		want []openai.ChatCompletionMessage
+	}{
+		{
+			name: "legacy prompt",
+			path: "/v1/completions",
+			body: `{"prompt":"hello there"}`,
+			want: []openai.ChatCompletionMessage{
+				{Role: openai.ChatMessageRoleUser, Content: "hello there"},
+			},
+		},
+		{
+			name: "chat completions multi-turn",
+			path: "/v1/chat/completions",
+			body: `{"messages":[{"role":"system","content":"be nice"},{"role":"user","content":"hi"}]}`,
+			want: []openai.ChatCompletionMessage{
+				{Role: "system", Content: "be nice"},
+				{Role: "user", Content: "hi"},
+			},
+		},
+		{
+			name: "messages array present under a different path",
+			path: "/proxy/custom",
+			body: `{"messages":[{"role":"user","content":"hi"}]}`,
+			want: []openai.ChatCompletionMessage{
+				{Role: "user", Content: "hi"},
+			},
+		},
+		{
+			name: "entries missing role or content are skipped",
+			path: "/v1/chat/completions",
+			body: `{"messages":[{"role":"user","content":""},{"role":"","content":"hi"},{"role":"user","content":"ok"}]}`,
+			want: []openai.ChatCompletionMessage{
+				{Role: "user", Content: "ok"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var bodyMap map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.body), &bodyMap); err != nil {
+				t.Fatalf("unmarshal test body: %v", err)
+			}
+
+			got := extractChatMessages(tt.path, bodyMap)
+			if len(got) != len(tt.want) {
+				t.Fatalf("got %d message(s), want %d: %+v", len(got), len(tt.want), got)
+			}
+			for i := range got {
+				if !reflect.DeepEqual(got[i], tt.want[i]) {
+					t.Errorf("message %d = %+v, want %+v", i, got[i], tt.want[i])
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"context"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+
+	healthPb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// healthServer implements grpc.health.v1.Health with a real Watch: each
+// subscriber gets the current status immediately, then a push whenever
+// setStatus flips it, instead of the server always reporting SERVING.
+type healthServer struct {
+	mu          sync.Mutex
+	status      map[string]healthPb.HealthCheckResponse_ServingStatus
+	subscribers map[string][]chan healthPb.HealthCheckResponse_ServingStatus
+}
+
+// newHealthServer starts the unqualified "" service (which covers the whole
+// process, matching what Envoy's grpc_health_v1 checker and
+// grpc_health_probe query by default) as SERVING; any other service name
+// reports SERVICE_UNKNOWN until setStatus records a status for it.
+func newHealthServer() *healthServer {
+	return &healthServer{
+		status: map[string]healthPb.HealthCheckResponse_ServingStatus{
+			"": healthPb.HealthCheckResponse_SERVING,
+		},
+		subscribers: make(map[string][]chan healthPb.HealthCheckResponse_ServingStatus),
+	}
+}
+
+func (s *healthServer) Check(ctx context.Context, in *healthPb.HealthCheckRequest) (*healthPb.HealthCheckResponse, error) {
+	log.Printf("[HealthCheck] Received health check request: %+v", in)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &healthPb.HealthCheckResponse{Status: s.statusLocked(in.Service)}, nil
+}
+
+// Watch sends the current status on subscribe, then pushes a fresh
+// HealthCheckResponse every time setStatus records a transition for
+// in.Service, until the client cancels or the stream errors.
+func (s *healthServer) Watch(in *healthPb.HealthCheckRequest, srv healthPb.Health_WatchServer) error {
+	log.Printf("[HealthWatch] Received watch request: %+v", in)
+
+	updates := make(chan healthPb.HealthCheckResponse_ServingStatus, 1)
+	s.mu.Lock()
+	current := s.statusLocked(in.Service)
+	s.subscribers[in.Service] = append(s.subscribers[in.Service], updates)
+	s.mu.Unlock()
+	defer s.unsubscribe(in.Service, updates)
+
+	if err := srv.Send(&healthPb.HealthCheckResponse{Status: current}); err != nil {
+		return err
+	}
+
+	for {
+		select {
+		case <-srv.Context().Done():
+			log.Printf("[HealthWatch] Subscriber for %q gone, tearing down", in.Service)
+			return nil
+		case status := <-updates:
+			if err := srv.Send(&healthPb.HealthCheckResponse{Status: status}); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// statusLocked reads the tracked status for service, defaulting to
+// SERVICE_UNKNOWN like the upstream grpc.health.v1 server does for a
+// service nothing has ever reported for. Caller must hold s.mu.
+func (s *healthServer) statusLocked(service string) healthPb.HealthCheckResponse_ServingStatus {
+	if status, ok := s.status[service]; ok {
+		return status
+	}
+	return healthPb.HealthCheckResponse_SERVICE_UNKNOWN
+}
+
+func (s *healthServer) unsubscribe(service string, updates chan healthPb.HealthCheckResponse_ServingStatus) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	subs := s.subscribers[service]
+	for i, ch := range subs {
+		if ch == updates {
+			s.subscribers[service] = append(subs[:i], subs[i+1:]...)
+			break
+		}
+	}
+}
+
+// setStatus records a new status for service and notifies current Watch
+// subscribers, but only on an actual transition so a flapping probe
+// doesn't spam subscribers with repeats of the status they already have.
+func (s *healthServer) setStatus(service string, status healthPb.HealthCheckResponse_ServingStatus) {
+	s.mu.Lock()
+	if s.statusLocked(service) == status {
+		s.mu.Unlock()
+		return
+	}
+	s.status[service] = status
+	subs := append([]chan healthPb.HealthCheckResponse_ServingStatus{}, s.subscribers[service]...)
+	s.mu.Unlock()
+
+	log.Printf("[HealthWatch] Service %q transitioned to %s", service, status)
+	for _, ch := range subs {
+		select {
+		case ch <- status:
+		default:
+			// Replace the stale pending update so the subscriber still ends
+			// up sending the latest status.
+			log.Printf("[HealthWatch] Subscriber for %q is slow, replacing its pending update", service)
+			select {
+			case <-ch:
+			default:
+			}
+			select {
+			case ch <- status:
+			default:
+			}
+		}
+	}
+}
+
+// probeGuardianUpstream periodically checks the Guardian API and flips hs's
+// status between SERVING and NOT_SERVING accordingly, so Envoy's health
+// check actually opens the circuit when Guardian is down instead of always
+// passing. Runs until ctx is cancelled.
+func probeGuardianUpstream(ctx context.Context, hs *healthServer) {
+	interval := probeInterval()
+	timeout := probeTimeout(interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	log.Printf("[HealthProbe] Probing %s every %s (timeout %s)", fullBaseURL, interval, timeout)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if probeUpstreamHealthy(timeout) {
+				hs.setStatus("", healthPb.HealthCheckResponse_SERVING)
+			} else {
+				hs.setStatus("", healthPb.HealthCheckResponse_NOT_SERVING)
+			}
+		}
+	}
+}
+
+// probeInterval reads GUARDIAN_PROBE_INTERVAL_SECONDS, defaulting to 10s.
+func probeInterval() time.Duration {
+	raw := os.Getenv("GUARDIAN_PROBE_INTERVAL_SECONDS")
+	if raw == "" {
+		return 10 * time.Second
+	}
+	secs, err := strconv.Atoi(raw)
+	if err != nil || secs <= 0 {
+		log.Printf("[HealthProbe] Ignoring invalid GUARDIAN_PROBE_INTERVAL_SECONDS=%q, using default", raw)
+		return 10 * time.Second
+	}
+	return time.Duration(secs) * time.Second
+}
+
+// probeTimeout bounds a single probe request to at most half the probe
+// interval (capped at 5s) so a hung upstream - the most realistic way
+// Guardian goes "unhealthy" - can't block the probe loop indefinitely
+// instead of tripping NOT_SERVING.
+func probeTimeout(interval time.Duration) time.Duration {
+	timeout := interval / 2
+	if timeout > 5*time.Second {
+		timeout = 5 * time.Second
+	}
+	if timeout <= 0 {
+		timeout = 1 * time.Second
+	}
+	return timeout
+}
+
+func probeUpstreamHealthy(timeout time.Duration) bool {
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Get(fullBaseURL)
+	if err != nil {
+		log.Printf("[HealthProbe] Upstream probe failed: %v", err)
+		return false
+	}
+	defer resp.Body.Close()
+	return resp.StatusCode < http.StatusInternalServerError
+}
@@ -25,15 +25,38 @@ import (
 )
 
 type server struct{}
-type healthServer struct{}
+
+// streamState tracks the per-stream bookkeeping a single Process call needs
+// to evaluate risk across a streamed (SSE) response instead of only on the
+// fully-buffered body. A new streamState is created for every gRPC stream
+// Envoy opens, so it never needs to be keyed or locked.
+type streamState struct {
+	ctx            context.Context // trace context recovered from inbound headers
+	path           string          // ":path" pseudo-header, stashed from RequestHeaders
+	streaming      bool            // request asked for "stream": true
+	accumulated    string          // full assistant text reassembled so far
+	uncheckedSince int             // rune count of accumulated not yet covered by a risk check
+	bytesSent      int64           // response body bytes already forwarded downstream
+	blocked        bool            // a risk check has already tripped for this stream
+	pendingLine    string          // trailing partial SSE line carried over from the previous chunk
+}
+
+const (
+	// sseCheckWindowRunes is how many new characters of reassembled
+	// delta.content we wait for before re-running checkRisk on a streamed
+	// response. Small enough to catch risky completions quickly, large
+	// enough that we're not hammering Guardian on every few-byte chunk.
+	sseCheckWindowRunes = 200
+)
 
 var (
-	apiKey      = os.Getenv("GUARDIAN_API_KEY")
-	baseURL     = os.Getenv("GUARDIAN_URL")
-	fullBaseURL = baseURL + "/openai/v1"
-	modelName   = "granite-guardian"
-	riskyToken  = "Yes"
-	client      openai.Client
+	apiKey         = os.Getenv("GUARDIAN_API_KEY")
+	baseURL        = os.Getenv("GUARDIAN_URL")
+	fullBaseURL    = baseURL + "/openai/v1"
+	modelName      = "granite-guardian"
+	riskyToken     = "Yes"
+	client         openai.Client
+	guardianConfig *Config
 )
 
 func init() {
@@ -44,23 +67,27 @@ func init() {
 		log.Fatal("GUARDIAN_URL env var is not set")
 	}
 
-	cfg := openai.DefaultConfig(apiKey)
-	cfg.BaseURL = fullBaseURL
-	client = *openai.NewClientWithConfig(cfg)
-}
-
-func (s *healthServer) Check(ctx context.Context, in *healthPb.HealthCheckRequest) (*healthPb.HealthCheckResponse, error) {
-	log.Printf("[HealthCheck] Received health check request: %+v", in)
-	return &healthPb.HealthCheckResponse{Status: healthPb.HealthCheckResponse_SERVING}, nil
-}
+	oaiCfg := openai.DefaultConfig(apiKey)
+	oaiCfg.BaseURL = fullBaseURL
+	client = *openai.NewClientWithConfig(oaiCfg)
 
-func (s *healthServer) Watch(in *healthPb.HealthCheckRequest, srv healthPb.Health_WatchServer) error {
-	log.Printf("[HealthWatch] Received watch request: %+v", in)
-	return status.Error(codes.Unimplemented, "Watch is not implemented")
+	var err error
+	guardianConfig, err = loadConfig()
+	if err != nil {
+		log.Fatalf("loading guardian config: %v", err)
+	}
 }
 
 func (s *server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 	log.Println("[Process] Starting processing loop")
+	streamStart := time.Now()
+	inFlightStreams.Inc()
+	defer func() {
+		inFlightStreams.Dec()
+		extProcStreamDuration.Observe(time.Since(streamStart).Seconds())
+	}()
+
+	st := &streamState{ctx: context.Background()}
 	for {
 		req, err := srv.Recv()
 		if err == io.EOF {
@@ -83,12 +110,18 @@ func (s *server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 		switch r := req.Request.(type) {
 		case *extProcPb.ProcessingRequest_RequestHeaders:
 			log.Println("[Process] Processing RequestHeaders")
+			st.path = headerValue(r.RequestHeaders.Headers, ":path")
+			st.ctx = extractTraceContext(r.RequestHeaders.Headers)
+			log.Printf("[Process] Request path: %s", st.path)
+
+			_, span := tracer.Start(st.ctx, "ext_proc.request_headers")
 			// pass through headers untouched
 			resp = &extProcPb.ProcessingResponse{
 				Response: &extProcPb.ProcessingResponse_RequestHeaders{
 					RequestHeaders: &extProcPb.HeadersResponse{},
 				},
 			}
+			span.End()
 			log.Println("[Process] RequestHeaders processed, passing through response unchanged")
 
 		case *extProcPb.ProcessingRequest_RequestBody:
@@ -103,8 +136,11 @@ func (s *server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 				return status.Errorf(codes.InvalidArgument, "invalid request body: %v", err)
 			}
 
-			prompt, _ := bodyMap["prompt"].(string)
-			log.Printf("[Process] Extracted prompt: %s", prompt)
+			messages := extractChatMessages(st.path, bodyMap)
+			log.Printf("[Process] Extracted %d message(s) for risk check", len(messages))
+
+			st.streaming, _ = bodyMap["stream"].(bool)
+			log.Printf("[Process] Request streaming: %v", st.streaming)
 
 			if os.Getenv("DISABLE_PROMPT_RISK_CHECK") == "yes" {
 				log.Println("[Process] Prompt risk check disabled via env var, allowing request")
@@ -114,34 +150,17 @@ func (s *server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 					},
 				}
 			} else {
-				if checkRisk(prompt) {
-					log.Println("[Process] Risky prompt detected, returning 403")
-
+				immediate, body := evaluateRisk(st.ctx, messages, []byte(`{"error":"Prompt blocked by content policy"}`), "request")
+				if immediate != nil {
 					resp = &extProcPb.ProcessingResponse{
 						Response: &extProcPb.ProcessingResponse_ImmediateResponse{
-							ImmediateResponse: &extProcPb.ImmediateResponse{
-								Status: &statusPb.HttpStatus{
-									Code: statusPb.StatusCode_Forbidden,
-								},
-								Body: []byte(`{"error":"Prompt blocked by content policy"}`),
-								Headers: &extProcPb.HeaderMutation{
-									SetHeaders: []*corePb.HeaderValueOption{
-										{
-											Header: &corePb.HeaderValue{
-												Key:   "Content-Type",
-												Value: "application/json",
-											},
-										},
-									},
-								},
-							},
+							ImmediateResponse: immediate,
 						},
 					}
 				} else {
-					log.Println("[Process] Prompt safe, allowing request")
 					resp = &extProcPb.ProcessingResponse{
 						Response: &extProcPb.ProcessingResponse_RequestBody{
-							RequestBody: &extProcPb.BodyResponse{},
+							RequestBody: body,
 						},
 					}
 				}
@@ -153,23 +172,36 @@ func (s *server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 			}
 
 		case *extProcPb.ProcessingRequest_ResponseHeaders:
-			log.Println("[Process] Processing ResponseHeaders, instructing Envoy to buffer response body")
+			bodyMode := filterPb.ProcessingMode_BUFFERED
+			if st.streaming {
+				bodyMode = filterPb.ProcessingMode_STREAMED
+			}
+			log.Printf("[Process] Processing ResponseHeaders, response body mode: %v", bodyMode)
 			resp = &extProcPb.ProcessingResponse{
 				Response: &extProcPb.ProcessingResponse_ResponseHeaders{
 					ResponseHeaders: &extProcPb.HeadersResponse{},
 				},
 				ModeOverride: &filterPb.ProcessingMode{
 					ResponseHeaderMode: filterPb.ProcessingMode_SKIP,
-					ResponseBodyMode:   filterPb.ProcessingMode_BUFFERED,
+					ResponseBodyMode:   bodyMode,
 				},
 			}
-			log.Println("[Process] ResponseHeaders processed, buffering response body")
+			log.Println("[Process] ResponseHeaders processed")
 			if err := srv.Send(resp); err != nil {
 				log.Printf("[Process] Error sending response headers: %v", err)
 				return status.Errorf(codes.Unknown, "cannot send stream response: %v", err)
 			}
 
 		case *extProcPb.ProcessingRequest_ResponseBody:
+			if st.streaming {
+				resp = handleStreamedResponseBody(st, r.ResponseBody)
+				if err := srv.Send(resp); err != nil {
+					log.Printf("[Process] Error sending response: %v", err)
+					return status.Errorf(codes.Unknown, "cannot send stream response: %v", err)
+				}
+				break
+			}
+
 			log.Println("[Process] Processing ResponseBody")
 			rb := r.ResponseBody
 			log.Printf("[Process] ResponseBody received, EndOfStream: %v", rb.EndOfStream)
@@ -204,33 +236,17 @@ func (s *server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 					},
 				}
 			} else {
-				if checkRisk(generated) {
-					log.Println("[Process] Risky LLM output detected, blocking response")
+				immediate, body := evaluateRisk(st.ctx, assistantMessage(generated), []byte(`{"error":"LLM output blocked by safety filter"}`), "response")
+				if immediate != nil {
 					resp = &extProcPb.ProcessingResponse{
 						Response: &extProcPb.ProcessingResponse_ImmediateResponse{
-							ImmediateResponse: &extProcPb.ImmediateResponse{
-								Status: &statusPb.HttpStatus{
-									Code: statusPb.StatusCode_Forbidden,
-								},
-								Body: []byte(`{"error":"LLM output blocked by safety filter"}`),
-								Headers: &extProcPb.HeaderMutation{
-									SetHeaders: []*corePb.HeaderValueOption{
-										{
-											Header: &corePb.HeaderValue{
-												Key:   "Content-Type",
-												Value: "application/json",
-											},
-										},
-									},
-								},
-							},
+							ImmediateResponse: immediate,
 						},
 					}
 				} else {
-					log.Println("[Process] LLM output safe, allowing response")
 					resp = &extProcPb.ProcessingResponse{
 						Response: &extProcPb.ProcessingResponse_ResponseBody{
-							ResponseBody: &extProcPb.BodyResponse{},
+							ResponseBody: body,
 						},
 					}
 				}
@@ -248,39 +264,263 @@ func (s *server) Process(srv extProcPb.ExternalProcessor_ProcessServer) error {
 	}
 }
 
-func checkRisk(userQuery string) bool {
-	log.Printf("üëÆ‚Äç‚ôÄÔ∏è [Guardian] Checking risk on: '%s'\n", userQuery)
-	log.Printf("‚Üí Sending to: %s/chat/completions with model '%s'\n", fullBaseURL, modelName)
+// handleStreamedResponseBody processes one ResponseBody chunk of a STREAMED
+// (stream: true) completion. It reassembles delta.content out of the SSE
+// frames in the chunk, re-checks risk on a sliding window of accumulated
+// text, and either passes the chunk through unchanged, swaps it for a safe
+// completion once a risky verdict lands (or, for a block or fail-closed
+// error before any bytes were forwarded, ends the stream with an
+// ImmediateResponse), or suppresses it outright once the stream has already
+// been blocked.
+func handleStreamedResponseBody(st *streamState, rb *extProcPb.HttpBody) *extProcPb.ProcessingResponse {
+	if st.blocked {
+		// Already replaced the stream with a safe completion; swallow
+		// whatever the upstream keeps sending so it never reaches the client.
+		log.Println("[Process] Stream already blocked, suppressing further chunks")
+		return bodyMutationResponse([]byte{})
+	}
+
+	deltas, pending := parseSSEDeltaContent(st.pendingLine, rb.Body)
+	st.pendingLine = pending
+	for _, delta := range deltas {
+		st.accumulated += delta
+		st.uncheckedSince += len([]rune(delta))
+	}
+
+	dueForCheck := st.uncheckedSince >= sseCheckWindowRunes || rb.EndOfStream
+	if dueForCheck && st.accumulated != "" {
+		st.uncheckedSince = 0
+		immediate, body := evaluateRisk(st.ctx, assistantMessage(st.accumulated), []byte(`{"error":"LLM output blocked by safety filter"}`), "response")
+		if immediate != nil || body.GetResponse().GetBodyMutation() != nil {
+			log.Println("[Process] Risky streamed output detected mid-stream, terminating stream")
+			st.blocked = true
+
+			if st.bytesSent == 0 && immediate != nil {
+				return &extProcPb.ProcessingResponse{
+					Response: &extProcPb.ProcessingResponse_ImmediateResponse{
+						ImmediateResponse: immediate,
+					},
+				}
+			}
+
+			return bodyMutationResponse(safeCompletionSSEFrame())
+		}
+
+		// annotate-header has no effect here: per the ext_proc proto,
+		// CommonResponse.HeaderMutation on a body response only takes effect
+		// when the body mode is BUFFERED, and this path only runs in
+		// STREAMED mode. There's no trailers mechanism wired up for this
+		// processor, so the action is a documented no-op for streamed
+		// responses rather than silently dropped or half-applied.
+		if body.GetResponse().GetHeaderMutation() != nil {
+			log.Println("[Process] Category crossed its threshold mid-stream, but annotate-header has no effect on streamed responses")
+		}
+	}
+
+	st.bytesSent += int64(len(rb.Body))
+	return &extProcPb.ProcessingResponse{
+		Response: &extProcPb.ProcessingResponse_ResponseBody{
+			ResponseBody: &extProcPb.BodyResponse{},
+		},
+	}
+}
+
+// bodyMutationResponse builds a ResponseBody ProcessingResponse that
+// replaces the chunk Envoy is holding with body.
+func bodyMutationResponse(body []byte) *extProcPb.ProcessingResponse {
+	return &extProcPb.ProcessingResponse{
+		Response: &extProcPb.ProcessingResponse_ResponseBody{
+			ResponseBody: &extProcPb.BodyResponse{
+				Response: &extProcPb.CommonResponse{
+					BodyMutation: &extProcPb.BodyMutation{
+						Mutation: &extProcPb.BodyMutation_Body{
+							Body: body,
+						},
+					},
+				},
+			},
+		},
+	}
+}
 
-	resp, err := client.CreateChatCompletion(context.Background(), openai.ChatCompletionRequest{
-		Model: modelName,
-		Messages: []openai.ChatCompletionMessage{
+// safeCompletionSSEFrame renders an OpenAI-shaped chat.completion.chunk
+// carrying a canned safe-completion message, terminated by the usual
+// "data: [DONE]" sentinel clients expect at the end of a stream.
+func safeCompletionSSEFrame() []byte {
+	chunk := map[string]interface{}{
+		"object": "chat.completion.chunk",
+		"choices": []map[string]interface{}{
 			{
-				Role:    openai.ChatMessageRoleUser,
-				Content: userQuery,
+				"index": 0,
+				"delta": map[string]interface{}{
+					"content": "I can't continue that response.",
+				},
+				"finish_reason": "content_filter",
 			},
 		},
-		Temperature: 0.01,
-		MaxTokens:   50,
-	})
+	}
+	encoded, _ := json.Marshal(chunk)
+	return []byte("data: " + string(encoded) + "\n\ndata: [DONE]\n\n")
+}
+
+// parseSSEDeltaContent pulls the delta.content strings out of the
+// "data: {...}" frames in an SSE chunk, skipping the "data: [DONE]"
+// sentinel and any non-JSON lines. A ResponseBody chunk is an arbitrary
+// network read, not a guaranteed whole SSE frame, so a "data: {...}" line
+// can split across two consecutive chunks: carry is any trailing partial
+// line left over from the previous call (empty on the first), and the
+// returned pending is the new trailing partial line - prepend it to the
+// next chunk the same way. Only a line complete in this call is parsed.
+func parseSSEDeltaContent(carry string, chunk []byte) (deltas []string, pending string) {
+	data := carry + string(chunk)
+	lines := strings.Split(data, "\n")
+	if !strings.HasSuffix(data, "\n") {
+		pending = lines[len(lines)-1]
+		lines = lines[:len(lines)-1]
+	}
+
+	for _, line := range lines {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "data: ") {
+			continue
+		}
+		payload := strings.TrimPrefix(line, "data: ")
+		if payload == "[DONE]" {
+			continue
+		}
+
+		var frame struct {
+			Choices []struct {
+				Delta struct {
+					Content string `json:"content"`
+				} `json:"delta"`
+			} `json:"choices"`
+		}
+		if err := json.Unmarshal([]byte(payload), &frame); err != nil {
+			log.Printf("[Process] Skipping unparsable SSE frame: %v", err)
+			continue
+		}
+		for _, choice := range frame.Choices {
+			if choice.Delta.Content != "" {
+				deltas = append(deltas, choice.Delta.Content)
+			}
+		}
+	}
+	return deltas, pending
+}
+
+// evaluateRisk runs the configured category policy against messages and
+// turns the result into the pieces a Process case needs: either an
+// ImmediateResponse (block, or a fail-closed Guardian error) or a
+// BodyResponse carrying whatever mutation the winning action calls for.
+func evaluateRisk(ctx context.Context, messages []openai.ChatCompletionMessage, blockedBody []byte, phase string) (*extProcPb.ImmediateResponse, *extProcPb.BodyResponse) {
+	verdicts, err := checkRisk(ctx, messages, phase)
 	if err != nil {
-		log.Fatalf("Risk model call failed: %v", err)
+		log.Printf("[Process] Guardian check failed: %v", err)
+		if guardianConfig.OnError == OnErrorFailOpen {
+			log.Println("[Process] on_error=fail_open, allowing despite Guardian error")
+			return nil, &extProcPb.BodyResponse{}
+		}
+		log.Println("[Process] on_error=fail_closed, blocking despite Guardian error")
+		return forbiddenImmediateResponse([]byte(`{"error":"risk check unavailable"}`)), nil
+	}
+
+	action, verdict := decideAction(verdicts)
+	switch action {
+	case ActionBlock:
+		log.Printf("[Process] Category %q crossed its threshold, blocking", verdict.Category)
+		return forbiddenImmediateResponse(blockedBody), nil
+
+	case ActionRedact:
+		log.Printf("[Process] Category %q crossed its threshold, redacting body", verdict.Category)
+		return nil, &extProcPb.BodyResponse{
+			Response: &extProcPb.CommonResponse{
+				BodyMutation: &extProcPb.BodyMutation{
+					Mutation: &extProcPb.BodyMutation_Body{
+						Body: []byte(`{"redacted":"content removed by guardian policy"}`),
+					},
+				},
+			},
+		}
+
+	case ActionAnnotateHeader:
+		log.Printf("[Process] Category %q crossed its threshold, annotating header", verdict.Category)
+		return nil, &extProcPb.BodyResponse{
+			Response: &extProcPb.CommonResponse{
+				HeaderMutation: &extProcPb.HeaderMutation{
+					SetHeaders: []*corePb.HeaderValueOption{
+						{
+							Header: &corePb.HeaderValue{
+								Key:   "x-guardian-risk-category",
+								Value: string(verdict.Category),
+							},
+						},
+					},
+				},
+			},
+		}
+
+	default:
+		return nil, &extProcPb.BodyResponse{}
 	}
+}
 
-	result := strings.TrimSpace(resp.Choices[0].Message.Content)
-	log.Printf("üõ°Ô∏è Risk Model Response: %s\n", result)
+// forbiddenImmediateResponse is the 403 shape both the prompt and response
+// risk checks return when a category's action is "block".
+func forbiddenImmediateResponse(body []byte) *extProcPb.ImmediateResponse {
+	return &extProcPb.ImmediateResponse{
+		Status: &statusPb.HttpStatus{
+			Code: statusPb.StatusCode_Forbidden,
+		},
+		Body: body,
+		Headers: &extProcPb.HeaderMutation{
+			SetHeaders: []*corePb.HeaderValueOption{
+				{
+					Header: &corePb.HeaderValue{
+						Key:   "Content-Type",
+						Value: "application/json",
+					},
+				},
+			},
+		},
+	}
+}
 
-	return strings.EqualFold(result, riskyToken)
+// headerValue looks up a header (including Envoy's ":path" pseudo-header)
+// by key from a RequestHeaders HeaderMap.
+func headerValue(headers *corePb.HeaderMap, key string) string {
+	if headers == nil {
+		return ""
+	}
+	for _, h := range headers.Headers {
+		if h.Key == key {
+			if h.Value != "" {
+				return h.Value
+			}
+			return string(h.RawValue)
+		}
+	}
+	return ""
 }
 
 func main() {
+	shutdownTracing := initTracing()
+	defer shutdownTracing(context.Background())
+	startMetricsServer()
+
 	lis, err := net.Listen("tcp", ":50051")
 	if err != nil {
 		log.Fatalf("[Main] Failed to listen: %v", err)
 	}
 	s := grpc.NewServer()
 	extProcPb.RegisterExternalProcessorServer(s, &server{})
-	healthPb.RegisterHealthServer(s, &healthServer{})
+
+	hs := newHealthServer()
+	healthPb.RegisterHealthServer(s, hs)
+
+	probeCtx, stopProbe := context.WithCancel(context.Background())
+	go probeGuardianUpstream(probeCtx, hs)
+
 	log.Println("[Main] Starting gRPC server on port :50051")
 
 	gracefulStop := make(chan os.Signal, 1)
@@ -288,6 +528,7 @@ func main() {
 	go func() {
 		<-gracefulStop
 		log.Println("[Main] Received shutdown signal, exiting after 1 second")
+		stopProbe()
 		time.Sleep(1 * time.Second)
 		os.Exit(0)
 	}()
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseSSEDeltaContent(t *testing.T) {
+	tests := []struct {
+		name        string
+		carry       string
+		chunk       string
+		wantDeltas  []string
+		wantPending string
+	}{
+		{
+			name:       "single complete frame",
+			chunk:      "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\n",
+			wantDeltas: []string{"hi"},
+		},
+		{
+			name:       "done sentinel skipped",
+			chunk:      "data: {\"choices\":[{\"delta\":{\"content\":\"hi\"}}]}\n\ndata: [DONE]\n\n",
+			wantDeltas: []string{"hi"},
+		},
+		{
+			name:        "frame split across chunk boundary",
+			chunk:       "data: {\"choices\":[{\"delta\":{\"content\":\"he",
+			wantDeltas:  nil,
+			wantPending: "data: {\"choices\":[{\"delta\":{\"content\":\"he",
+		},
+		{
+			name:       "carry completes the prior partial line",
+			carry:      "data: {\"choices\":[{\"delta\":{\"content\":\"he",
+			chunk:      "llo\"}}]}\n\n",
+			wantDeltas: []string{"hello"},
+		},
+		{
+			name:       "unparsable line is skipped, not dropped silently into pending",
+			chunk:      "data: not-json\n\ndata: {\"choices\":[{\"delta\":{\"content\":\"ok\"}}]}\n\n",
+			wantDeltas: []string{"ok"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			deltas, pending := parseSSEDeltaContent(tt.carry, []byte(tt.chunk))
+			if !reflect.DeepEqual(deltas, tt.wantDeltas) {
+				t.Errorf("deltas = %v, want %v", deltas, tt.wantDeltas)
+			}
+			if pending != tt.wantPending {
+				t.Errorf("pending = %q, want %q", pending, tt.wantPending)
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"os"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var (
+	guardianDecisionsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "guardian_decisions_total",
+		Help: "Guardian risk decisions, by phase, category and verdict.",
+	}, []string{"phase", "category", "verdict"})
+
+	guardianCheckDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "guardian_check_duration_seconds",
+		Help:    "Latency of a single Guardian category check, by phase.",
+		Buckets: prometheus.DefBuckets,
+	}, []string{"phase"})
+
+	extProcStreamDuration = promauto.NewHistogram(prometheus.HistogramOpts{
+		Name:    "guardian_ext_proc_stream_duration_seconds",
+		Help:    "Wall-clock duration of a single ext_proc Process stream.",
+		Buckets: prometheus.DefBuckets,
+	})
+
+	inFlightStreams = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "guardian_ext_proc_in_flight_streams",
+		Help: "Number of ext_proc Process streams currently open.",
+	})
+
+	guardianCacheHitsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "guardian_cache_hits_total",
+		Help: "Verdict cache hits, by cached verdict (risky/safe).",
+	}, []string{"verdict"})
+
+	guardianCacheMissesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Name: "guardian_cache_misses_total",
+		Help: "Verdict cache misses, requiring a live Guardian call.",
+	})
+)
+
+// startMetricsServer exposes /metrics on its own HTTP listener, separate
+// from the gRPC port, so scraping it can never be blocked by or compete
+// with ext_proc traffic. Port is configurable via METRICS_PORT.
+func startMetricsServer() {
+	port := os.Getenv("METRICS_PORT")
+	if port == "" {
+		port = "9090"
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.Handler())
+
+	log.Printf("[Metrics] Serving /metrics on :%s", port)
+	go func() {
+		if err := http.ListenAndServe(":"+port, mux); err != nil {
+			log.Printf("[Metrics] Metrics server stopped: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"go.opentelemetry.io/otel/trace"
+
+	corePb "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+)
+
+// tracer is used by Process and the Guardian call path to start spans under
+// whatever trace context extractTraceContext recovered from Envoy.
+var tracer trace.Tracer
+
+// initTracing installs the W3C tracecontext propagator and, if
+// OTEL_EXPORTER_OTLP_ENDPOINT is set, an OTLP/gRPC exporter so spans
+// started here land in the same backend as the rest of the mesh. Returns a
+// shutdown func to flush on exit; it's a no-op when no endpoint is set.
+func initTracing() func(context.Context) error {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	endpoint := os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT")
+	if endpoint == "" {
+		log.Println("[Tracing] OTEL_EXPORTER_OTLP_ENDPOINT not set, tracing spans are created but not exported")
+		tracer = otel.Tracer("guardian-ext-proc")
+		return func(context.Context) error { return nil }
+	}
+
+	exporter, err := otlptracegrpc.New(context.Background(),
+		otlptracegrpc.WithEndpoint(endpoint),
+		otlptracegrpc.WithInsecure(),
+	)
+	if err != nil {
+		log.Printf("[Tracing] Failed to create OTLP exporter, spans will not be exported: %v", err)
+		tracer = otel.Tracer("guardian-ext-proc")
+		return func(context.Context) error { return nil }
+	}
+
+	res, err := resource.New(context.Background(), resource.WithAttributes(semconv.ServiceName("guardian-ext-proc")))
+	if err != nil {
+		log.Printf("[Tracing] Failed to build resource: %v", err)
+		res = resource.Default()
+	}
+
+	tp := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(tp)
+	tracer = tp.Tracer("guardian-ext-proc")
+
+	log.Printf("[Tracing] Exporting spans via OTLP/gRPC to %s", endpoint)
+	return tp.Shutdown
+}
+
+// extractTraceContext recovers a W3C tracecontext (traceparent/tracestate)
+// from the headers Envoy forwards on RequestHeaders, so spans started in
+// Process nest under the same trace as the inbound Envoy access log entry
+// instead of starting a disconnected one.
+func extractTraceContext(headers *corePb.HeaderMap) context.Context {
+	carrier := propagation.MapCarrier{}
+	if headers != nil {
+		for _, h := range headers.Headers {
+			key := strings.ToLower(h.Key)
+			if key != "traceparent" && key != "tracestate" {
+				continue
+			}
+			value := h.Value
+			if value == "" {
+				value = string(h.RawValue)
+			}
+			carrier[key] = value
+		}
+	}
+	return otel.GetTextMapPropagator().Extract(context.Background(), carrier)
+}